@@ -0,0 +1,187 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"gopkg.in/yaml.v3"
+)
+
+var configFile = flag.String("config.file", "", "Path to a YAML config file declaring per-script options; supersedes -path for script discovery")
+
+// ScriptConfig holds the per-script options that -config.file can declare,
+// overriding the global flag defaults for that one script.
+type ScriptConfig struct {
+	Interval    string            `yaml:"interval,omitempty"`
+	Timeout     string            `yaml:"timeout,omitempty"`
+	Env         map[string]string `yaml:"env,omitempty"`
+	Args        []string          `yaml:"args,omitempty"`
+	Enabled     *bool             `yaml:"enabled,omitempty"`
+	Interpreter string            `yaml:"interpreter,omitempty"`
+}
+
+// Config is the top-level -config.file manifest.
+type Config struct {
+	ScriptsDir string                  `yaml:"scripts_dir,omitempty"`
+	Scripts    map[string]ScriptConfig `yaml:"scripts,omitempty"`
+}
+
+var (
+	configMu     sync.RWMutex
+	loadedConfig = &Config{}
+)
+
+// reloadConfig re-reads -config.file and atomically swaps it in. It is a
+// no-op (not an error) when -config.file wasn't set, so it's safe to call
+// unconditionally from the SIGHUP handler and /-/reload.
+func reloadConfig() error {
+	if *configFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(*configFile)
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to read config file", "file", *configFile, "err", err)
+		return err
+	}
+	cfg := &Config{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		level.Error(logger).Log("msg", "failed to parse config file", "file", *configFile, "err", err)
+		return err
+	}
+
+	configMu.Lock()
+	loadedConfig = cfg
+	configMu.Unlock()
+	level.Info(logger).Log("msg", "config reloaded", "file", *configFile, "scripts", len(cfg.Scripts))
+	return nil
+}
+
+// currentConfig returns the most recently loaded config. Callers must not
+// mutate the result.
+func currentConfig() *Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return loadedConfig
+}
+
+// scriptConfigFor returns the ScriptConfig declared for name, if any.
+func scriptConfigFor(name string) (ScriptConfig, bool) {
+	cfg := currentConfig()
+	sc, ok := cfg.Scripts[name]
+	return sc, ok
+}
+
+// scriptsDir returns the effective script directory: the config file's
+// scripts_dir if one was loaded, falling back to today's -path flag.
+func scriptsDir() string {
+	if dir := currentConfig().ScriptsDir; dir != "" {
+		return dir
+	}
+	return *path
+}
+
+// resolveScriptPath validates a user-supplied script name (e.g. from
+// /probe?script=...) and resolves it to a path inside scriptsDir(). It
+// rejects names containing a path separator or "..", and names that aren't a
+// recognized script (see isScript), so a request can't escape scriptsDir()
+// or execute an undeclared file.
+func resolveScriptPath(name string) (string, error) {
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid script name %q", name)
+	}
+	if !isScript(name) {
+		return "", fmt.Errorf("unknown script %q", name)
+	}
+	dir := scriptsDir()
+	scriptPath := filepath.Join(dir, name)
+	if filepath.Dir(scriptPath) != filepath.Clean(dir) {
+		return "", fmt.Errorf("invalid script name %q", name)
+	}
+	return scriptPath, nil
+}
+
+// watchReloadSignal re-reads the config file whenever the process receives
+// SIGHUP, matching the rest of the Prometheus ecosystem.
+func watchReloadSignal() {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		for range sighup {
+			level.Info(logger).Log("msg", "reloading config on SIGHUP")
+			reloadConfig()
+		}
+	}()
+}
+
+// reloadHandler implements POST /-/reload for reloading -config.file without
+// restarting the process or dropping the in-memory cache.
+func reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	if *configFile == "" {
+		http.Error(w, "no -config.file configured", http.StatusBadRequest)
+		return
+	}
+	if err := reloadConfig(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// interpreterFor returns the interpreter to invoke a script with: the
+// config's explicit interpreter if declared, otherwise one inferred from the
+// file extension (falling back to bash, today's only supported interpreter).
+func interpreterFor(name string) string {
+	if sc, ok := scriptConfigFor(name); ok && sc.Interpreter != "" {
+		return sc.Interpreter
+	}
+	switch {
+	case strings.HasSuffix(name, ".py"):
+		return "python3"
+	case strings.HasSuffix(name, ".pl"):
+		return "perl"
+	case strings.HasSuffix(name, ".ps1"):
+		return "pwsh"
+	default:
+		return "bash"
+	}
+}
+
+// scriptEnv builds the environment for a script's exec.Cmd: the process
+// environment plus any env map declared for it in -config.file.
+func scriptEnv(sc ScriptConfig) []string {
+	if len(sc.Env) == 0 {
+		return os.Environ()
+	}
+	env := os.Environ()
+	for k, v := range sc.Env {
+		env = append(env, k+"="+v)
+	}
+	return env
+}
+
+// parseDurationOr returns the parsed duration for s, or fallback if s is
+// empty or fails to parse.
+func parseDurationOr(s string, fallback time.Duration) time.Duration {
+	if s == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fallback
+	}
+	return d
+}
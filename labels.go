@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// metricLineRe splits a sample line into its metric name, optional {…}
+// label block, and the remainder (value and optional timestamp).
+var metricLineRe = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{[^{}]*\})?(.*)$`)
+
+// metricName extracts just the metric name from an already-rewritten sample
+// line, for grouping samples by family.
+func metricName(line string) (string, bool) {
+	m := metricLineRe.FindStringSubmatch(line)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// seriesKey extracts the metric name plus label block from an
+// already-rewritten sample line, giving a key that identifies its series
+// (name+labelset) regardless of value or timestamp. Used to detect two
+// scripts emitting the same series.
+func seriesKey(line string) (string, bool) {
+	m := metricLineRe.FindStringSubmatch(line)
+	if m == nil {
+		return "", false
+	}
+	return m[1] + m[2], true
+}
+
+// parseConstLabels parses -labels ("k1=v1,k2=v2") into a map, skipping
+// malformed pairs.
+func parseConstLabels() map[string]string {
+	result := make(map[string]string)
+	if *labels == "" {
+		return result
+	}
+	for _, pair := range strings.Split(*labels, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			continue
+		}
+		result[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return result
+}
+
+// prefixedName applies -prefix to a metric name.
+func prefixedName(name string) string {
+	if *prefix == "" {
+		return name
+	}
+	return *prefix + name
+}
+
+// rewriteSampleLine applies -prefix to a sample's metric name and merges
+// constLabels into its {…} block, adding one if the line didn't have any.
+func rewriteSampleLine(line string, constLabels map[string]string) string {
+	m := metricLineRe.FindStringSubmatch(line)
+	if m == nil {
+		return line
+	}
+	name, labelBlock, remainder := m[1], m[2], m[3]
+	return prefixedName(name) + mergeLabelBlock(labelBlock, constLabels) + remainder
+}
+
+// mergeLabelBlock folds constLabels into an existing "{k=\"v\",...}" block
+// (existing labels win on conflict), returning "" if the result is empty.
+func mergeLabelBlock(block string, constLabels map[string]string) string {
+	values := make(map[string]string)
+	var order []string
+
+	addOrKeep := func(key, value string) {
+		if _, ok := values[key]; !ok {
+			order = append(order, key)
+		}
+		values[key] = value
+	}
+
+	if block != "" {
+		inner := strings.TrimSuffix(strings.TrimPrefix(block, "{"), "}")
+		for _, pair := range splitLabelPairs(inner) {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			addOrKeep(strings.TrimSpace(kv[0]), kv[1])
+		}
+	}
+	for key, value := range constLabels {
+		if _, ok := values[key]; ok {
+			continue // a script's own label wins over a constant label of the same name
+		}
+		addOrKeep(key, fmt.Sprintf("%q", value))
+	}
+
+	if len(order) == 0 {
+		return ""
+	}
+	sort.Strings(order)
+	pairs := make([]string, len(order))
+	for i, key := range order {
+		pairs[i] = key + "=" + values[key]
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// splitLabelPairs splits a {…} block's interior on commas that aren't inside
+// a quoted label value.
+func splitLabelPairs(s string) []string {
+	var parts []string
+	var cur strings.Builder
+	inQuotes := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			inQuotes = !inQuotes
+			cur.WriteByte(c)
+		case c == ',' && !inQuotes:
+			parts = append(parts, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	if cur.Len() > 0 {
+		parts = append(parts, cur.String())
+	}
+	return parts
+}
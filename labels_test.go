@@ -0,0 +1,112 @@
+package main
+
+import "testing"
+
+func TestPrefixedName(t *testing.T) {
+	old := *prefix
+	defer func() { *prefix = old }()
+
+	*prefix = ""
+	if got := prefixedName("up"); got != "up" {
+		t.Errorf("prefixedName with empty prefix = %q, want %q", got, "up")
+	}
+
+	*prefix = "myapp_"
+	if got := prefixedName("up"); got != "myapp_up" {
+		t.Errorf("prefixedName = %q, want %q", got, "myapp_up")
+	}
+}
+
+func TestParseConstLabels(t *testing.T) {
+	old := *labels
+	defer func() { *labels = old }()
+
+	*labels = ""
+	if got := parseConstLabels(); len(got) != 0 {
+		t.Errorf("parseConstLabels with empty -labels = %v, want empty", got)
+	}
+
+	*labels = "env=prod, region =us-east , =skip, bare"
+	got := parseConstLabels()
+	want := map[string]string{"env": "prod", "region": "us-east"}
+	if len(got) != len(want) {
+		t.Fatalf("parseConstLabels = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("parseConstLabels[%q] = %q, want %q", k, got[k], v)
+		}
+	}
+}
+
+func TestRewriteSampleLine(t *testing.T) {
+	old := *prefix
+	defer func() { *prefix = old }()
+	*prefix = "myapp_"
+
+	cases := []struct {
+		name        string
+		line        string
+		constLabels map[string]string
+		want        string
+	}{
+		{
+			name:        "no existing labels, one const label",
+			line:        "up 1",
+			constLabels: map[string]string{"job": "probe"},
+			want:        `myapp_up{job="probe"} 1`,
+		},
+		{
+			name:        "existing labels win over const label of same name",
+			line:        `requests_total{job="own"} 5`,
+			constLabels: map[string]string{"job": "probe", "region": "us-east"},
+			want:        `myapp_requests_total{job="own",region="us-east"} 5`,
+		},
+		{
+			name:        "no const labels leaves existing block untouched",
+			line:        `up{script_name="foo.sh"} 1`,
+			constLabels: map[string]string{},
+			want:        `myapp_up{script_name="foo.sh"} 1`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := rewriteSampleLine(tc.line, tc.constLabels); got != tc.want {
+				t.Errorf("rewriteSampleLine(%q) = %q, want %q", tc.line, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSplitLabelPairs(t *testing.T) {
+	got := splitLabelPairs(`a="1,2",b="3"`)
+	want := []string{`a="1,2"`, `b="3"`}
+	if len(got) != len(want) {
+		t.Fatalf("splitLabelPairs = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("splitLabelPairs[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestSeriesKey(t *testing.T) {
+	key1, ok := seriesKey(`up{job="x"} 1`)
+	if !ok {
+		t.Fatal("seriesKey: expected ok=true")
+	}
+	key2, ok := seriesKey(`up{job="x"} 0`)
+	if !ok {
+		t.Fatal("seriesKey: expected ok=true")
+	}
+	if key1 != key2 {
+		t.Errorf("seriesKey should ignore value: %q != %q", key1, key2)
+	}
+
+	key3, _ := seriesKey(`up{job="y"} 1`)
+	if key1 == key3 {
+		t.Errorf("seriesKey should distinguish different labelsets: %q == %q", key1, key3)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/common/promlog"
+)
+
+var (
+	logLevel  = flag.String("log.level", "info", "Only log messages with the given severity or above. One of: [debug, info, warn, error]")
+	logFormat = flag.String("log.format", "logfmt", "Output format of log messages. One of: [logfmt, json]")
+
+	// logger is the exporter-wide structured logger. It's a no-op until
+	// initLogger runs in main(), so package-level init code can still log
+	// safely before flags are parsed.
+	logger log.Logger = log.NewNopLogger()
+)
+
+// initLogger builds the real logger from the parsed -log.level / -log.format
+// flags. Must be called after flag.Parse().
+func initLogger() {
+	cfg := &promlog.Config{
+		Level:  &promlog.AllowedLevel{},
+		Format: &promlog.AllowedFormat{},
+	}
+	if err := cfg.Level.Set(*logLevel); err != nil {
+		level.Warn(logger).Log("msg", "invalid -log.level, defaulting to info", "value", *logLevel)
+	}
+	if err := cfg.Format.Set(*logFormat); err != nil {
+		level.Warn(logger).Log("msg", "invalid -log.format, defaulting to logfmt", "value", *logFormat)
+	}
+	logger = promlog.New(cfg)
+}
@@ -1,19 +1,22 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"flag"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/exporter-toolkit/web"
 )
 
 // Cache structure to store metrics and error statuses
@@ -22,16 +25,22 @@ type Cache struct {
 	ExitCode        int
 	FileAccessError int
 	ParseError      int
+	LastStart       time.Time     // When this script's last run started
+	LastDuration    time.Duration // How long that run took
+	NextRun         time.Time     // When the scheduler expects to run this script next
 }
 
 var (
 	// Command-line flags
-	interval = flag.Int("interval", 300, "Interval for metrics collection in seconds")
-	timeout  = flag.Int("timeout", 200, "Timeout for scripts (in seconds)")
-	labels   = flag.String("labels", "", "Additional labels for metrics")
-	path     = flag.String("path", "/scripts", "Path to directory with bash scripts")
-	prefix   = flag.String("prefix", "", "Prefix for metrics names")
-	port     = flag.String("port", ":9000", "Port on which to expose metrics")
+	interval      = flag.Int("interval", 300, "Default interval for metrics collection in seconds (per-script, see \"# interval: N\")")
+	timeout       = flag.Int("timeout", 200, "Timeout for scripts (in seconds)")
+	labels        = flag.String("labels", "", "Additional labels for metrics")
+	path          = flag.String("path", "/scripts", "Path to directory with bash scripts")
+	prefix        = flag.String("prefix", "", "Prefix for metrics names")
+	port          = flag.String("port", ":9000", "Port on which to expose metrics")
+	mode          = flag.String("mode", "cache", "Collection mode: cache, probe, or both")
+	concurrency   = flag.Int("concurrency", runtime.NumCPU(), "Maximum number of scripts to run concurrently")
+	webConfigFile = flag.String("web.config.file", "", "Path to a web config file that can enable TLS or basic auth, see the exporter-toolkit docs")
 
 	// Cache and synchronization
 	cache      = make(map[string]Cache)
@@ -40,10 +49,16 @@ var (
 
 // Function to execute a script and update its metrics in the cache
 func updateScriptMetrics(scriptPath string) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(*timeout)*time.Second)
+	start := time.Now()
+	scriptName := filepath.Base(scriptPath)
+	sc, _ := scriptConfigFor(scriptName)
+
+	execTimeout := parseDurationOr(sc.Timeout, time.Duration(*timeout)*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, "bash", scriptPath)
+	cmd := exec.CommandContext(ctx, interpreterFor(scriptName), append([]string{scriptPath}, sc.Args...)...)
+	cmd.Env = scriptEnv(sc)
 	output, err := cmd.Output()
 	exitCode := 0
 	parseError := 0
@@ -53,90 +68,152 @@ func updateScriptMetrics(scriptPath string) {
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			exitCode = exitErr.ExitCode()
 		} else {
-			log.Printf("Error executing script %s: %v", scriptPath, err)
+			level.Error(logger).Log("msg", "error executing script", "script", scriptName, "err", err)
 			return
 		}
 	}
 
-	var metrics []string
-	scanner := bufio.NewScanner(bytes.NewReader(output))
-	for scanner.Scan() {
-		line := scanner.Text()
-		// Check if it's a valid Prometheus line (no empty lines, etc.)
-		if line != "" {
-			metrics = append(metrics, line)
-		}
-	}
-	if err := scanner.Err(); err != nil {
-		log.Printf("Error scanning output from %s: %v", scriptPath, err)
+	parsed := parseScriptOutput(scriptName, output)
+	if parsed.dropped > 0 {
 		parseError = 1
 	}
+	mergeHelpType(scriptName, parsed.help, parsed.typ)
+	duration := time.Since(start)
+	level.Debug(logger).Log("msg", "script executed", "script", scriptName, "exit_code", exitCode, "duration_ms", duration.Milliseconds(), "bytes", len(output))
+
+	scriptDurationHistogram.WithLabelValues(scriptName).Observe(duration.Seconds())
+	scriptOutputBytesGauge.WithLabelValues(scriptName).Set(float64(len(output)))
 
 	cacheMutex.Lock()
-	cache[filepath.Base(scriptPath)] = Cache{Metrics: metrics, ExitCode: exitCode, ParseError: parseError}
+	cache[scriptName] = Cache{
+		Metrics:      parsed.samples,
+		ExitCode:     exitCode,
+		ParseError:   parseError,
+		LastStart:    start,
+		LastDuration: duration,
+	}
 	cacheMutex.Unlock()
 }
 
-// Function to find and execute all scripts in the specified directory
-func updateAllMetrics() {
-	scriptCount := 0
-	err := filepath.Walk(*path, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			log.Printf("File access error %s: %v", path, err)
-			cacheMutex.Lock()
-			cache[filepath.Base(path)] = Cache{FileAccessError: 1}
-			cacheMutex.Unlock()
-			return nil
-		}
-		if info.IsDir() || !strings.HasSuffix(info.Name(), ".sh") {
-			return nil
-		}
-		scriptCount++
-		go updateScriptMetrics(path)
-		return nil
-	})
-
-	// Exit the program with an error if no scripts are found
-	if err == nil && scriptCount == 0 {
-		log.Fatalf("No scripts found in directory %s", *path)
+// Function to convert metrics to Prometheus (or OpenMetrics) format. Self
+// metrics (script_exporter_error, durations, Go/process stats, ...) come
+// from the client_golang registry; script-produced metrics were already
+// validated and had -prefix/-labels applied in updateScriptMetrics, and
+// their HELP/TYPE lines are merged here instead of being repeated per
+// script. Existing metric names are preserved so dashboards don't break.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	openMetrics := wantsOpenMetrics(r.Header.Get("Accept"))
+	format := expfmt.NewFormat(expfmt.TypeTextPlain)
+	if openMetrics {
+		format = expfmt.NewFormat(expfmt.TypeOpenMetrics)
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
 	}
-}
 
-// Function to convert metrics to Prometheus format
-func metricsHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8") // Set content type for Prometheus
+	scrapeCounter.Inc()
 	cacheMutex.RLock()
-	defer cacheMutex.RUnlock()
+	for scriptName, cacheData := range cache {
+		scriptErrorGauge.WithLabelValues("script_exit_code", scriptName).Set(float64(cacheData.ExitCode))
+		scriptErrorGauge.WithLabelValues("file_access_error", scriptName).Set(float64(cacheData.FileAccessError))
+		scriptErrorGauge.WithLabelValues("json_parse_error", scriptName).Set(float64(cacheData.ParseError))
+	}
+	cacheMutex.RUnlock()
 
+	selfMetrics, err := gatherSelfMetrics(format)
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to gather self metrics", "err", err)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(selfMetrics)
+
+	// Group every script-produced sample by metric name so each family's
+	// merged HELP/TYPE ends up immediately followed by all of its samples,
+	// as strict OpenMetrics parsers require, rather than all metadata lines
+	// up front and all samples after.
+	familySamples := make(map[string][]string)
+	addSample := func(line string) {
+		if name, ok := metricName(line); ok {
+			familySamples[name] = append(familySamples[name], line)
+		}
+	}
+
+	seenSeries := make(map[string]string) // series key -> script that emitted it first
+	constLabels := parseConstLabels()
+
+	cacheMutex.RLock()
 	for scriptName, cacheData := range cache {
-		// Output script exit code, file access error, and JSON parsing error as separate metrics
-		fmt.Fprintf(w, "script_exporter_error{error_name=\"script_exit_code\",script_name=\"%s\"} %d\n", scriptName, cacheData.ExitCode)
-		fmt.Fprintf(w, "script_exporter_error{error_name=\"file_access_error\",script_name=\"%s\"} %d\n", scriptName, cacheData.FileAccessError)
-		fmt.Fprintf(w, "script_exporter_error{error_name=\"json_parse_error\",script_name=\"%s\"} %d\n", scriptName, cacheData.ParseError)
+		if !cacheData.LastStart.IsZero() {
+			addSample(rewriteSampleLine(fmt.Sprintf("script_duration_seconds{script_name=\"%s\"} %f", scriptName, cacheData.LastDuration.Seconds()), constLabels))
+			addSample(rewriteSampleLine(fmt.Sprintf("script_last_run_timestamp_seconds{script_name=\"%s\"} %d", scriptName, cacheData.LastStart.Unix()), constLabels))
+		}
 
-		// Output the metrics directly as they are in Prometheus format
+		// Collect the already-validated, already-rewritten metrics from this
+		// script, dropping any series (name+labelset) already emitted by
+		// another script so a colliding script can't poison the scrape.
 		for _, metric := range cacheData.Metrics {
-			fmt.Fprintf(w, "%s\n", metric)
+			if key, ok := seriesKey(metric); ok {
+				if owner, dup := seenSeries[key]; dup {
+					atomic.AddInt64(&seriesCollisionsTotal, 1)
+					level.Warn(logger).Log("msg", "dropping duplicate series across scripts", "series", key, "script", scriptName, "first_seen_script", owner)
+					continue
+				}
+				seenSeries[key] = scriptName
+			}
+			addSample(metric)
 		}
 	}
+	cacheMutex.RUnlock()
+
+	writeGroupedMetrics(&buf, familySamples)
+
+	if openMetrics {
+		buf.WriteString("# EOF\n")
+	}
+	w.Write(buf.Bytes())
 }
 
 func main() {
 	// Parse command-line flags
 	flag.Parse()
+	initLogger()
+	initMetrics()
 
-	// Start cache update loop in background
-	go func() {
-		for {
-			updateAllMetrics()
-			time.Sleep(time.Duration(*interval) * time.Second)
+	if *configFile != "" {
+		if err := reloadConfig(); err != nil {
+			level.Error(logger).Log("msg", "failed to load config file", "file", *configFile, "err", err)
+			os.Exit(1)
 		}
-	}()
+		watchReloadSignal()
+	}
+
+	// Start the bounded collection scheduler in background, unless this
+	// instance is probe-only
+	if cacheModeEnabled() {
+		sem := make(chan struct{}, *concurrency)
+		go func() {
+			ticker := time.NewTicker(schedulerTickInterval)
+			defer ticker.Stop()
+			for {
+				runScheduler(sem)
+				<-ticker.C
+			}
+		}()
+	}
 
 	// Configure and start HTTP server
 	http.HandleFunc("/metrics", metricsHandler)
-	log.Printf("Starting server on %s", *port)
-	if err := http.ListenAndServe(*port, nil); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	http.HandleFunc("/probe", probeHandler)
+	http.HandleFunc("/-/reload", reloadHandler)
+	level.Info(logger).Log("msg", "starting server", "address", *port)
+	srv := &http.Server{Addr: *port}
+	webConfig := &web.FlagConfig{
+		WebListenAddresses: &[]string{*port},
+		WebConfigFile:      webConfigFile,
+	}
+	if err := web.ListenAndServe(srv, webConfig, logger); err != nil {
+		level.Error(logger).Log("msg", "failed to start server", "err", err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+
+	scriptErrorGauge        *prometheus.GaugeVec
+	scriptDurationHistogram *prometheus.HistogramVec
+	scriptOutputBytesGauge  *prometheus.GaugeVec
+	scrapeCounter           prometheus.Counter
+)
+
+// initMetrics builds and registers the exporter's self-metrics. It must run
+// after flag.Parse() so -prefix and -labels are honored.
+func initMetrics() {
+	cl := prometheus.Labels(parseConstLabels())
+
+	scriptErrorGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        prefixedName("script_exporter_error"),
+		Help:        "Per-script error state (1=error, 0=ok), keyed by error_name.",
+		ConstLabels: cl,
+	}, []string{"error_name", "script_name"})
+
+	scriptDurationHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:        prefixedName("script_exporter_script_duration_seconds"),
+		Help:        "Duration of each script run, in seconds.",
+		ConstLabels: cl,
+	}, []string{"script_name"})
+
+	scriptOutputBytesGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        prefixedName("script_exporter_script_output_bytes"),
+		Help:        "Size of each script's validated stdout, in bytes.",
+		ConstLabels: cl,
+	}, []string{"script_name"})
+
+	scrapeCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        prefixedName("script_exporter_scrape_total"),
+		Help:        "Total number of /metrics scrapes served.",
+		ConstLabels: cl,
+	})
+
+	registry.MustRegister(scriptErrorGauge, scriptDurationHistogram, scriptOutputBytesGauge, scrapeCounter)
+	registry.MustRegister(prometheus.NewGoCollector())
+	registry.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	registry.MustRegister(newAtomicCounter(prefixedName("script_exporter_parse_errors_total"), "Cumulative malformed sample lines dropped across all scripts.", cl, &parseErrorsTotal))
+	registry.MustRegister(newAtomicCounter(prefixedName("script_exporter_help_type_conflicts_total"), "Cumulative HELP/TYPE redeclarations that disagreed with the first one seen.", cl, &helpTypeConflictsTotal))
+	registry.MustRegister(newAtomicCounter(prefixedName("script_exporter_skipped_total"), "Cumulative scripts skipped because a previous run was still in flight.", cl, &skippedTotal))
+	registry.MustRegister(newAtomicCounter(prefixedName("script_exporter_series_collisions_total"), "Cumulative sample series (name+labelset) emitted by more than one script and dropped.", cl, &seriesCollisionsTotal))
+}
+
+// atomicCounter adapts a plain int64 counter (updated via atomic.AddInt64
+// from across the codebase) into a prometheus.Collector, so it can be
+// registered and scraped like any other self-metric.
+type atomicCounter struct {
+	desc   *prometheus.Desc
+	valPtr *int64
+}
+
+func newAtomicCounter(name, help string, constLabels prometheus.Labels, valPtr *int64) *atomicCounter {
+	return &atomicCounter{desc: prometheus.NewDesc(name, help, nil, constLabels), valPtr: valPtr}
+}
+
+func (c *atomicCounter) Describe(ch chan<- *prometheus.Desc) { ch <- c.desc }
+
+func (c *atomicCounter) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, float64(atomic.LoadInt64(c.valPtr)))
+}
+
+// gatherSelfMetrics renders the registry's self-metrics in the given
+// exposition format, for concatenation alongside script-produced metrics.
+func gatherSelfMetrics(format expfmt.Format) ([]byte, error) {
+	families, err := registry.Gather()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := expfmt.NewEncoder(&buf, format)
+	for _, mf := range families {
+		if err := enc.Encode(mf); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
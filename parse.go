@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-kit/log/level"
+)
+
+// sampleLineRe matches a Prometheus exposition sample: a metric name, an
+// optional {label="value",...} block, a value, and an optional timestamp.
+var sampleLineRe = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*(\{[^{}]*\})?\s+\S+(\s+-?[0-9]+)?$`)
+
+var (
+	// parseErrorsTotal is the cumulative count of malformed sample lines
+	// rejected across all scripts, exposed as script_exporter_parse_errors_total.
+	parseErrorsTotal int64
+
+	// helpTypeConflictsTotal counts HELP/TYPE redeclarations for a metric name
+	// that disagree with the first one seen, exposed as
+	// script_exporter_help_type_conflicts_total.
+	helpTypeConflictsTotal int64
+
+	// seriesCollisionsTotal counts sample series (name+labelset) emitted by
+	// more than one script in the same scrape; the duplicate is dropped so it
+	// can't poison the whole exposition, exposed as
+	// script_exporter_series_collisions_total.
+	seriesCollisionsTotal int64
+
+	// helpByMetric and typeByMetric hold the first HELP/TYPE line seen for a
+	// given metric name, so later scripts redeclaring it can be checked for
+	// conflicts instead of blindly duplicated in the output.
+	helpMu       sync.Mutex
+	helpByMetric = make(map[string]string)
+	typeByMetric = make(map[string]string)
+)
+
+// parsedOutput is the result of validating one script's raw stdout: the
+// sample lines that passed validation, plus any HELP/TYPE lines it declared.
+type parsedOutput struct {
+	samples []string
+	help    map[string]string // metric name -> HELP text
+	typ     map[string]string // metric name -> TYPE text
+	dropped int
+}
+
+// parseScriptOutput validates raw script stdout line by line. Malformed
+// sample lines are dropped (and counted) rather than poisoning the whole
+// exposition; # HELP and # TYPE lines are captured separately so they can be
+// merged across scripts instead of being repeated verbatim.
+func parseScriptOutput(scriptName string, output []byte) parsedOutput {
+	result := parsedOutput{
+		help: make(map[string]string),
+		typ:  make(map[string]string),
+	}
+	constLabels := parseConstLabels()
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t")
+		if line == "" {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "# HELP "):
+			name, text, ok := splitMetaLine(line, "# HELP ")
+			if ok {
+				result.help[prefixedName(name)] = text
+			}
+		case strings.HasPrefix(line, "# TYPE "):
+			name, text, ok := splitMetaLine(line, "# TYPE ")
+			if ok {
+				result.typ[prefixedName(name)] = text
+			}
+		case strings.HasPrefix(line, "#"):
+			// Other comments (including a trailing "# EOF") are ignored.
+			continue
+		default:
+			if sampleLineRe.MatchString(line) {
+				result.samples = append(result.samples, rewriteSampleLine(line, constLabels))
+			} else {
+				result.dropped++
+				level.Debug(logger).Log("msg", "dropping malformed metric line", "script", scriptName, "line", line)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		level.Error(logger).Log("msg", "error scanning script output", "script", scriptName, "err", err)
+		result.dropped++
+	}
+
+	if result.dropped > 0 {
+		atomic.AddInt64(&parseErrorsTotal, int64(result.dropped))
+	}
+	return result
+}
+
+// splitMetaLine splits a "# HELP name text" or "# TYPE name text" line into
+// its metric name and remaining text.
+func splitMetaLine(line, prefix string) (name, text string, ok bool) {
+	rest := strings.TrimPrefix(line, prefix)
+	parts := strings.SplitN(rest, " ", 2)
+	if len(parts) == 0 || parts[0] == "" {
+		return "", "", false
+	}
+	name = parts[0]
+	if len(parts) == 2 {
+		text = parts[1]
+	}
+	return name, text, true
+}
+
+// mergeHelpType folds a script's HELP/TYPE declarations into the shared,
+// first-wins maps, logging and counting any conflicting redeclaration.
+func mergeHelpType(scriptName string, help, typ map[string]string) {
+	helpMu.Lock()
+	defer helpMu.Unlock()
+
+	for name, text := range help {
+		if existing, ok := helpByMetric[name]; ok {
+			if existing != text {
+				atomic.AddInt64(&helpTypeConflictsTotal, 1)
+				level.Warn(logger).Log("msg", "HELP conflict, keeping first value", "metric", name, "script", scriptName, "kept", existing, "ignored", text)
+			}
+			continue
+		}
+		helpByMetric[name] = text
+	}
+	for name, text := range typ {
+		if existing, ok := typeByMetric[name]; ok {
+			if existing != text {
+				atomic.AddInt64(&helpTypeConflictsTotal, 1)
+				level.Warn(logger).Log("msg", "TYPE conflict, keeping first value", "metric", name, "script", scriptName, "kept", existing, "ignored", text)
+			}
+			continue
+		}
+		typeByMetric[name] = text
+	}
+}
+
+// writeGroupedMetrics writes every known metric family (from merged
+// HELP/TYPE declarations and/or familySamples) in a deterministic (sorted)
+// order, with each family's HELP/TYPE immediately followed by its samples so
+// strict OpenMetrics parsers, which require metadata adjacent to the samples
+// it describes, can parse the response.
+func writeGroupedMetrics(w *bytes.Buffer, familySamples map[string][]string) {
+	helpMu.Lock()
+	defer helpMu.Unlock()
+
+	names := make(map[string]struct{}, len(helpByMetric)+len(typeByMetric)+len(familySamples))
+	for name := range helpByMetric {
+		names[name] = struct{}{}
+	}
+	for name := range typeByMetric {
+		names[name] = struct{}{}
+	}
+	for name := range familySamples {
+		names[name] = struct{}{}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		if text, ok := helpByMetric[name]; ok {
+			fmt.Fprintf(w, "# HELP %s %s\n", name, text)
+		}
+		if text, ok := typeByMetric[name]; ok {
+			fmt.Fprintf(w, "# TYPE %s %s\n", name, text)
+		}
+		for _, line := range familySamples[name] {
+			fmt.Fprintf(w, "%s\n", line)
+		}
+	}
+}
+
+// wantsOpenMetrics reports whether the request's Accept header prefers the
+// OpenMetrics exposition format over the classic Prometheus text format.
+func wantsOpenMetrics(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		if strings.HasPrefix(strings.TrimSpace(part), "application/openmetrics-text") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package main
+
+import "testing"
+
+func TestParseScriptOutputValidSamples(t *testing.T) {
+	old := *labels
+	defer func() { *labels = old }()
+	*labels = ""
+
+	output := []byte(`# HELP up Whether the target is up
+# TYPE up gauge
+up{job="x"} 1
+this is not a metric line
+requests_total 42
+`)
+	result := parseScriptOutput("test.sh", output)
+
+	if result.dropped != 1 {
+		t.Errorf("dropped = %d, want 1", result.dropped)
+	}
+	if len(result.samples) != 2 {
+		t.Fatalf("samples = %v, want 2 entries", result.samples)
+	}
+	if result.help["up"] != "Whether the target is up" {
+		t.Errorf("help[up] = %q, want %q", result.help["up"], "Whether the target is up")
+	}
+	if result.typ["up"] != "gauge" {
+		t.Errorf("typ[up] = %q, want %q", result.typ["up"], "gauge")
+	}
+}
+
+func TestParseScriptOutputAppliesPrefixAndLabels(t *testing.T) {
+	oldPrefix, oldLabels := *prefix, *labels
+	defer func() { *prefix, *labels = oldPrefix, oldLabels }()
+	*prefix = "myapp_"
+	*labels = "env=prod"
+
+	result := parseScriptOutput("test.sh", []byte("up 1\n"))
+	if len(result.samples) != 1 {
+		t.Fatalf("samples = %v, want 1 entry", result.samples)
+	}
+	want := `myapp_up{env="prod"} 1`
+	if result.samples[0] != want {
+		t.Errorf("samples[0] = %q, want %q", result.samples[0], want)
+	}
+}
+
+func TestMergeHelpTypeConflict(t *testing.T) {
+	helpMu.Lock()
+	helpByMetric = make(map[string]string)
+	typeByMetric = make(map[string]string)
+	helpMu.Unlock()
+	before := helpTypeConflictsTotal
+
+	mergeHelpType("a.sh", map[string]string{"up": "Is the target up"}, map[string]string{"up": "gauge"})
+	mergeHelpType("b.sh", map[string]string{"up": "A different description"}, map[string]string{"up": "gauge"})
+
+	helpMu.Lock()
+	kept := helpByMetric["up"]
+	helpMu.Unlock()
+	if kept != "Is the target up" {
+		t.Errorf("helpByMetric[up] = %q, want first-seen value %q", kept, "Is the target up")
+	}
+	if helpTypeConflictsTotal != before+1 {
+		t.Errorf("helpTypeConflictsTotal = %d, want %d", helpTypeConflictsTotal, before+1)
+	}
+}
+
+func TestWantsOpenMetrics(t *testing.T) {
+	cases := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"text/plain", false},
+		{"application/openmetrics-text; version=1.0.0", true},
+		{"text/plain, application/openmetrics-text;q=0.5", true},
+	}
+	for _, tc := range cases {
+		if got := wantsOpenMetrics(tc.accept); got != tc.want {
+			t.Errorf("wantsOpenMetrics(%q) = %v, want %v", tc.accept, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/log/level"
+)
+
+// schedulerTickInterval is how often the scheduler re-walks the script
+// directory to see what's due. Per-script intervals (see parseScriptInterval)
+// are only as precise as this tick.
+const schedulerTickInterval = time.Second
+
+// skippedTotal counts scripts that were due to run but whose previous run
+// hadn't finished yet, exposed as script_exporter_skipped_total.
+var skippedTotal int64
+
+// scriptState tracks the in-flight/scheduling state of one script across
+// scheduler ticks.
+type scriptState struct {
+	mu       sync.Mutex
+	running  bool
+	interval time.Duration
+	nextRun  time.Time
+}
+
+var (
+	scriptStatesMu sync.Mutex
+	scriptStates   = make(map[string]*scriptState)
+)
+
+// runScheduler walks the script directory once and dispatches every script
+// that is due to run onto the bounded worker pool backed by sem. Scripts
+// still running from a previous tick are skipped rather than re-dispatched.
+func runScheduler(sem chan struct{}) {
+	dir := scriptsDir()
+	scriptCount := 0
+	now := time.Now()
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			level.Debug(logger).Log("msg", "file access error", "path", p, "err", err)
+			cacheMutex.Lock()
+			cache[filepath.Base(p)] = Cache{FileAccessError: 1}
+			cacheMutex.Unlock()
+			return nil
+		}
+		if info.IsDir() || !isScript(info.Name()) {
+			return nil
+		}
+		scriptCount++
+		dispatchScript(p, now, sem)
+		return nil
+	})
+
+	// Exit the program with an error if no scripts are found
+	if err == nil && scriptCount == 0 {
+		level.Error(logger).Log("msg", "no scripts found in directory", "path", dir)
+		os.Exit(1)
+	}
+}
+
+// isScript reports whether name should be treated as a runnable script: a
+// recognized interpreter extension, or a name explicitly declared in
+// -config.file's scripts map.
+func isScript(name string) bool {
+	for _, ext := range []string{".sh", ".py", ".pl", ".ps1"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	_, ok := scriptConfigFor(name)
+	return ok
+}
+
+// dispatchScript runs scriptPath on the worker pool if it is due and not
+// already running, first applying its own "# interval: N" header if present.
+func dispatchScript(scriptPath string, now time.Time, sem chan struct{}) {
+	name := filepath.Base(scriptPath)
+	if sc, ok := scriptConfigFor(name); ok && sc.Enabled != nil && !*sc.Enabled {
+		return
+	}
+	st := scriptStateFor(name, scriptPath)
+
+	st.mu.Lock()
+	if st.running {
+		st.mu.Unlock()
+		atomic.AddInt64(&skippedTotal, 1)
+		level.Warn(logger).Log("msg", "skipping script, previous run still in flight", "script", name)
+		return
+	}
+	if !st.nextRun.IsZero() && now.Before(st.nextRun) {
+		st.mu.Unlock()
+		return
+	}
+	st.running = true
+	st.mu.Unlock()
+
+	go func() {
+		sem <- struct{}{}
+		defer func() { <-sem }()
+		defer func() {
+			st.mu.Lock()
+			st.running = false
+			st.nextRun = time.Now().Add(st.interval)
+			nextRun := st.nextRun
+			st.mu.Unlock()
+
+			cacheMutex.Lock()
+			if c, ok := cache[name]; ok {
+				c.NextRun = nextRun
+				cache[name] = c
+			}
+			cacheMutex.Unlock()
+		}()
+
+		updateScriptMetrics(scriptPath)
+	}()
+}
+
+// scriptStateFor returns the scriptState for name, creating it (and
+// resolving its interval) on first use.
+func scriptStateFor(name, scriptPath string) *scriptState {
+	scriptStatesMu.Lock()
+	defer scriptStatesMu.Unlock()
+
+	if st, ok := scriptStates[name]; ok {
+		return st
+	}
+	st := &scriptState{interval: time.Duration(*interval) * time.Second}
+	if d, ok := parseScriptInterval(scriptPath); ok {
+		st.interval = d
+	}
+	if sc, ok := scriptConfigFor(name); ok {
+		st.interval = parseDurationOr(sc.Interval, st.interval)
+	}
+	scriptStates[name] = st
+	level.Info(logger).Log("msg", "discovered script", "script", name, "interval", st.interval)
+	return st
+}
+
+// parseScriptInterval looks for a "# interval: N" comment in the first few
+// lines of a script and, if found, returns N as a per-script collection
+// interval overriding the global -interval default.
+func parseScriptInterval(scriptPath string) (time.Duration, bool) {
+	f, err := os.Open(scriptPath)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for i := 0; i < 20 && scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "#") {
+			continue
+		}
+		rest := strings.TrimSpace(strings.TrimPrefix(line, "#"))
+		if !strings.HasPrefix(rest, "interval:") {
+			continue
+		}
+		value := strings.TrimSpace(strings.TrimPrefix(rest, "interval:"))
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds <= 0 {
+			continue
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	return 0, false
+}
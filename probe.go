@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log/level"
+)
+
+// cacheModeEnabled reports whether -mode includes the background
+// cached-collection loop.
+func cacheModeEnabled() bool {
+	return *mode == "cache" || *mode == "both"
+}
+
+// probeModeEnabled reports whether -mode includes on-demand /probe execution.
+func probeModeEnabled() bool {
+	return *mode == "probe" || *mode == "both"
+}
+
+// probeHandler executes a single named script synchronously for this
+// request, blackbox_exporter-style: ?script=name.sh&param_FOO=bar runs
+// name.sh with PARAM_FOO=bar in its environment. The response carries the
+// script's own validated metrics plus a synthesized script_duration_seconds
+// and script_success, and honors X-Prometheus-Scrape-Timeout-Seconds.
+func probeHandler(w http.ResponseWriter, r *http.Request) {
+	if !probeModeEnabled() {
+		http.Error(w, "probe mode is disabled (see -mode)", http.StatusNotFound)
+		return
+	}
+
+	scriptName := r.URL.Query().Get("script")
+	if scriptName == "" {
+		http.Error(w, "missing required 'script' query parameter", http.StatusBadRequest)
+		return
+	}
+	scriptPath, err := resolveScriptPath(scriptName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sc, scDeclared := scriptConfigFor(scriptName)
+	if scDeclared && sc.Enabled != nil && !*sc.Enabled {
+		http.Error(w, fmt.Sprintf("script %q is disabled", scriptName), http.StatusForbidden)
+		return
+	}
+
+	probeTimeout := parseDurationOr(sc.Timeout, time.Duration(*timeout)*time.Second)
+	if h := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); h != "" {
+		if secs, err := strconv.ParseFloat(h, 64); err == nil && secs > 0 {
+			probeTimeout = time.Duration(secs * float64(time.Second))
+		}
+	}
+
+	env := scriptEnv(sc)
+	for key, values := range r.URL.Query() {
+		if !strings.HasPrefix(key, "param_") || len(values) == 0 {
+			continue
+		}
+		envName := "PARAM_" + strings.ToUpper(strings.TrimPrefix(key, "param_"))
+		env = append(env, fmt.Sprintf("%s=%s", envName, values[0]))
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), probeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	cmd := exec.CommandContext(ctx, interpreterFor(scriptName), append([]string{scriptPath}, sc.Args...)...)
+	cmd.Env = env
+	output, err := cmd.Output()
+	duration := time.Since(start).Seconds()
+
+	success := 1
+	if err != nil {
+		success = 0
+		level.Error(logger).Log("msg", "probe failed", "script", scriptName, "err", err)
+	}
+
+	// Unlike cached collection, a probe response never emits its own
+	// # HELP/# TYPE lines and isn't merged into /metrics, so there's nothing
+	// to fold into the shared helpByMetric/typeByMetric maps here.
+	parsed := parseScriptOutput(scriptName, output)
+	level.Debug(logger).Log("msg", "probe executed", "script", scriptName, "duration_ms", time.Duration(duration*float64(time.Second)).Milliseconds(), "bytes", len(output))
+
+	constLabels := parseConstLabels()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, line := range parsed.samples {
+		fmt.Fprintf(w, "%s\n", line)
+	}
+	fmt.Fprintf(w, "%s\n", rewriteSampleLine(fmt.Sprintf("script_duration_seconds{script_name=\"%s\"} %f", scriptName, duration), constLabels))
+	fmt.Fprintf(w, "%s\n", rewriteSampleLine(fmt.Sprintf("script_success{script_name=\"%s\"} %d", scriptName, success), constLabels))
+}